@@ -1,9 +1,11 @@
 package dat
 
 import (
+	"encoding/gob"
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
 	"sort"
 	"strconv"
 	"testing"
@@ -151,29 +153,113 @@ func TestStoreLoad(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = dat.Store("/Users/didi/Documents/go/test.dic")
+	path := t.TempDir() + "/test.dic"
+	err = dat.Store(path)
 	if err != nil {
 		t.Fatal(err)
 	}
 	dat2 := NewDoubleArrayTrie()
-	err = dat2.Load("/Users/didi/Documents/go/test.dic")
+	err = dat2.Load(path)
 	if err != nil {
 		t.Fatal(err)
 	}
+	errCount := 0
 	for i := 0; i < 100; i++ {
 		index, ok := dat2.IndexOf(samples[i])
-		fmt.Print(samples[i] + ":")
-		if ok {
-			fmt.Print(i == index)
-		} else {
-			fmt.Print("not ok")
+		if !ok || index != i {
+			errCount++
 		}
 		value := dat2.GetValue(samples[i])
-		fmt.Println(",val:", value)
+		if value != i {
+			errCount++
+		}
+	}
+	if errCount != 0 {
+		t.Fatalf("store/load error num %d", errCount)
 	}
 	fmt.Println("allocSize:", dat.allocSize)
 }
 
+// 测试LoadMmap加载出的DAT，查询结果应该和普通Load一致
+func TestStoreLoadMmap(t *testing.T) {
+	samples := makeSample(100000, 3, 8)
+	vals := make([]int, len(samples), len(samples))
+	for i := 0; i < len(samples); i++ {
+		vals[i] = i
+	}
+	dat := NewDoubleArrayTrie()
+	if err := dat.Build2(samples, vals); err != nil {
+		t.Fatal(err)
+	}
+	path := t.TempDir() + "/test_mmap.dic"
+	if err := dat.Store(path); err != nil {
+		t.Fatal(err)
+	}
+	dat2 := NewDoubleArrayTrie()
+	if err := dat2.LoadMmap(path); err != nil {
+		t.Fatal(err)
+	}
+	defer dat2.Close()
+	errCount := 0
+	for i := 0; i < 100; i++ {
+		index, ok := dat2.IndexOf(samples[i])
+		if !ok || index != i {
+			errCount++
+		}
+	}
+	if errCount != 0 {
+		t.Fatalf("mmap load error num %d", errCount)
+	}
+}
+
+// 测试旧版本gob格式的文件，能通过LoadGob正常加载
+func TestLoadGobBackwardCompat(t *testing.T) {
+	samples := []string{"a", "ab", "abc", "b"}
+	dat := NewDoubleArrayTrie()
+	if err := dat.Build1(samples); err != nil {
+		t.Fatal(err)
+	}
+	path := t.TempDir() + "/legacy.dic"
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := &DATExport{
+		Check:        toIntSlice(dat.check),
+		Base:         toIntSlice(dat.base),
+		Size:         dat.size,
+		AllocSize:    dat.allocSize,
+		Keys:         dat.keys,
+		KeySize:      dat.keySize,
+		NextCheckPos: dat.nextCheckPos,
+		Progress:     dat.progress,
+	}
+	if err := gob.NewEncoder(file).Encode(legacy); err != nil {
+		file.Close()
+		t.Fatal(err)
+	}
+	file.Close()
+
+	dat2 := NewDoubleArrayTrie()
+	if err := dat2.LoadGob(path); err != nil {
+		t.Fatal(err)
+	}
+	for i, sample := range samples {
+		index, ok := dat2.IndexOf(sample)
+		if !ok || index != i {
+			t.Errorf("LoadGob mismatch for %s: index=%d, ok=%v", sample, index, ok)
+		}
+	}
+}
+
+func toIntSlice(src []int32) []int {
+	dst := make([]int, len(src))
+	for i, v := range src {
+		dst[i] = int(v)
+	}
+	return dst
+}
+
 func TestExactMatch(t *testing.T) {
 	dat := new(DoubleArrayTrie)
 	dat.Build1([]string{"1", "2", "3"})
@@ -181,6 +267,81 @@ func TestExactMatch(t *testing.T) {
 	fmt.Println(index)
 }
 
+// 测试CommonPrefixSearch，验证能找到所有作为输入前缀的key
+func TestCommonPrefixSearch(t *testing.T) {
+	dat := NewDoubleArrayTrie()
+	keys := []string{"a", "ab", "abc", "abcd", "b"}
+	err := dat.Build1(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := dat.CommonPrefixSearch("abcde")
+	if len(results) != 4 {
+		t.Fatalf("expect 4 results, got %d", len(results))
+	}
+	for _, r := range results {
+		if keys[r.Index] != string([]rune("abcde")[:r.Length]) {
+			t.Errorf("result mismatch: index=%d, length=%d, key=%s", r.Index, r.Length, keys[r.Index])
+		}
+	}
+	// 不存在任何前缀
+	empty := dat.CommonPrefixSearch("xyz")
+	if len(empty) != 0 {
+		t.Fatalf("expect 0 results, got %d", len(empty))
+	}
+}
+
+// 测试MultiPatternMatch，验证能扫描出text中所有位置的命中
+func TestMultiPatternMatch(t *testing.T) {
+	dat := NewDoubleArrayTrie()
+	keys := []string{"he", "hers", "his", "she"}
+	err := dat.BuildWithSort(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "ushershe"
+	hits := dat.MultiPatternMatch(text)
+	if len(hits) == 0 {
+		t.Fatal("expect at least 1 hit")
+	}
+	for _, hit := range hits {
+		chs := []rune(text)
+		got := string(chs[hit.Start:hit.End])
+		if keys[hit.Index] != got {
+			t.Errorf("hit mismatch: got %s, want %s", got, keys[hit.Index])
+		}
+	}
+}
+
+// 回归测试：check[begin+0]==begin这个NULL转移探测，在两个不同节点恰好复用同一个begin时
+// 可能是巧合而不是真的命中——这组key/text就会触发（ccbc误判成ccbbc），
+// ExactMatchSearch/CommonPrefixSearch/MultiPatternMatch都必须在接受命中前核对解码出来的key
+func TestMultiPatternMatchRejectsCoincidentalNullHit(t *testing.T) {
+	keys := []string{"a", "aba", "cab", "cabca", "cb", "cba", "ccbbc", "ccc"}
+	dat := NewDoubleArrayTrie()
+	if err := dat.BuildWithSort(keys); err != nil {
+		t.Fatal(err)
+	}
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	text := "cbccbc"
+	chs := []rune(text)
+	for _, hit := range dat.MultiPatternMatch(text) {
+		got := string(chs[hit.Start:hit.End])
+		if sorted[hit.Index] != got {
+			t.Errorf("hit mismatch: got %q, matched key %q", got, sorted[hit.Index])
+		}
+	}
+	for _, key := range sorted {
+		if _, ok := dat.IndexOf(key); !ok {
+			t.Errorf("IndexOf(%s) = not found, want found", key)
+		}
+	}
+	if _, ok := dat.IndexOf("ccbc"); ok {
+		t.Error("IndexOf(ccbc) = found, want not found (ccbc is not a stored key)")
+	}
+}
+
 // 测试直接声明的切片的len和cap
 // 测试nil 切片是否调用len是否长度为0
 func TestSlice(t *testing.T) {
@@ -198,6 +359,71 @@ func (w *NilWriter) Write(p []byte) (n int, err error) {
 	return 0, nil
 }
 
+// 测试TAIL压缩模式下构建、精确匹配都正常工作
+func TestBuildCompressed(t *testing.T) {
+	samples := makeSample(100000, 3, 8)
+	dat := NewDoubleArrayTrie()
+	err := dat.BuildCompressed(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCount := 0
+	for i, sample := range samples {
+		index, ok := dat.IndexOf(sample)
+		if !ok || index != i {
+			errCount++
+		}
+	}
+	if errCount != 0 {
+		t.Fatalf("compressed build IndexOf error num %d", errCount)
+	}
+}
+
+// 测试TAIL压缩模式的trie经过Store/Load往返之后，tail缓冲区和compressed标记都还在，
+// 压缩产生的key依然能查到，并且Insert仍然会被errCompressedNotSupported拦住
+// （而不是像修复前那样因为compressed没被持久化，静默地把新分支写坏base/check）
+func TestStoreLoadCompressed(t *testing.T) {
+	samples := []string{"abcdefgh", "he", "hello", "helloworld"}
+	dat := NewDoubleArrayTrie()
+	if err := dat.BuildCompressed(samples); err != nil {
+		t.Fatal(err)
+	}
+	path := t.TempDir() + "/compressed.dic"
+	if err := dat.Store(path); err != nil {
+		t.Fatal(err)
+	}
+	dat2 := NewDoubleArrayTrie()
+	if err := dat2.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if !dat2.compressed {
+		t.Fatal("expect reloaded trie to still be marked compressed")
+	}
+	for i, sample := range samples {
+		index, ok := dat2.IndexOf(sample)
+		if !ok || index != i {
+			t.Errorf("IndexOf(%s) = %d, %v after reload, want %d, true", sample, index, ok, i)
+		}
+	}
+	if _, err := dat2.Insert("zzznotexist"); err != errCompressedNotSupported {
+		t.Errorf("Insert on reloaded compressed trie = %v, want errCompressedNotSupported", err)
+	}
+}
+
+// 对比开启/不开启TAIL压缩模式时，1M词库下base/check的allocSize差异
+func BenchmarkTailCompression(b *testing.B) {
+	log.SetOutput(&NilWriter{})
+	samples := makeSample(1000000, 3, 8)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		plain := NewDoubleArrayTrie()
+		plain.Build1(samples)
+		compressed := NewDoubleArrayTrie()
+		compressed.BuildCompressed(samples)
+		b.Logf("allocSize plain=%d, compressed=%d", plain.allocSize, compressed.allocSize)
+	}
+}
+
 func BenchmarkBuild(b *testing.B) {
 	sizes := []int64{
 		1000000,
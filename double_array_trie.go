@@ -1,11 +1,9 @@
 package dat
 
 import (
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"log"
-	"os"
 	"reflect"
 	"sort"
 	"time"
@@ -80,8 +78,8 @@ const (
 
 type key []rune
 type DoubleArrayTrie struct {
-	check        []int
-	base         []int
+	check        []int32     // 状态转移校验数组
+	base         []int32     // 状态转移数组
 	size         int         //对于base，check真正用到的大小
 	allocSize    int         // 分配的数组大小
 	keys         []key       // key list
@@ -89,32 +87,56 @@ type DoubleArrayTrie struct {
 	values       interface{} //k-v中的v
 	progress     int         // 构建进度，运行时非前缀key的数量
 	nextCheckPos int         //下一次insert可能开始的检查位置
+	compressed   bool        // 是否开启TAIL后缀压缩模式
+	tail         []rune      // TAIL压缩模式下，存放无分支尾部后缀的共享缓冲区
+	tailEntries  []tailEntry // tail中每一段后缀对应的元信息
+	mmapData     []byte      // LoadMmap映射的原始内存，base/check均指向其中的区域；非mmap加载时为nil
+
+	// childCodes记录每个节点当前挂着的所有孩子code，key是这个节点的身份标识（nodeKey）：
+	// 根节点固定为0，其它节点用"父节点cell的下标"（即parentBegin+parentCode，base[该下标]就是这个节点的begin）
+	// 之所以不能直接用begin当key，是因为双数组trie为了省内存，不同节点的begin允许取相同的值，
+	// 只要它们各自实际占用的cell不冲突即可；Insert/Delete第一次被调用时才会从现有keys里重建这份索引
+	childCodes map[int][]int
 }
 
-// 由于不想对外暴露DoubleArrayTrie的字段，但是gob协议中又需要编码
-// 所以被迫这里使用一个中间结构来达到目的
-type DATExport struct {
-	Check        []int
-	Base         []int
-	Size         int
-	AllocSize    int
-	Keys         []key
-	KeySize      int
-	Values       interface{}
-	Progress     int
-	NextCheckPos int
+// tailEntry 描述tail缓冲区中的一段后缀
+// offset/length定位d.tail中的[offset, offset+length)区间
+// left是该后缀所属key在keys列表中的索引
+type tailEntry struct {
+	offset int
+	length int
+	left   int
 }
 
 func NewDoubleArrayTrie() *DoubleArrayTrie {
 	return &DoubleArrayTrie{}
 }
 
+// getBase/setBase/getCheck/setCheck是对base/check的存取入口
+// base/check用int32存储是为了配合LoadMmap：mmap出来的内存按固定宽度的int32解读，
+// 不必在加载时整体转换成平台相关宽度的int，这里统一转换成int供调用方使用
+func (d *DoubleArrayTrie) getBase(i int) int {
+	return int(d.base[i])
+}
+
+func (d *DoubleArrayTrie) setBase(i int, v int) {
+	d.base[i] = int32(v)
+}
+
+func (d *DoubleArrayTrie) getCheck(i int) int {
+	return int(d.check[i])
+}
+
+func (d *DoubleArrayTrie) setCheck(i int, v int) {
+	d.check[i] = int32(v)
+}
+
 /*
 	对base，used，check扩容
 */
 func (d *DoubleArrayTrie) resize(newSize int) int {
-	base2 := make([]int, newSize, newSize)
-	check2 := make([]int, newSize, newSize)
+	base2 := make([]int32, newSize, newSize)
+	check2 := make([]int32, newSize, newSize)
 	if d.allocSize > 0 {
 		copy(base2, d.base)
 		copy(check2, d.check)
@@ -222,7 +244,7 @@ outer:
 		pos++
 		begin = pos - children[0].code
 		// 被占用
-		if d.check[pos] != 0 {
+		if d.getCheck(pos) != 0 {
 			nonZeroNum++
 			continue
 		} else if firstNonZero {
@@ -241,7 +263,7 @@ outer:
 			d.resize(int(float64(s) * rate))
 		}
 		for i := 1; i < len(children); i++ {
-			if d.check[begin+children[i].code] != 0 {
+			if d.getCheck(begin+children[i].code) != 0 {
 				// 之前这里写的continue导致了bug
 				continue outer
 			}
@@ -258,10 +280,17 @@ outer:
 		d.nextCheckPos = pos
 	}
 	for i := 0; i < len(children); i++ {
-		d.check[begin+children[i].code] = begin
+		d.setCheck(begin+children[i].code, begin)
 	}
 	// 针对孩子节点继续递归构建
 	for _, chi := range children {
+		// TAIL压缩：当前搜索范围内只剩一个key，说明从这里到叶子不会再有分支，
+		// 剩余的字符可以作为一整段后缀直接写入tail缓冲区，不必再逐字符分配base/check
+		if d.compressed && chi.right-chi.left == 1 && chi.depth < len(d.keys[chi.left]) {
+			d.setBase(begin+chi.code, d.appendTail(chi))
+			d.progress++
+			continue
+		}
 		nodes, err := d.fetch(chi)
 		if err != nil {
 			log.Fatal(err)
@@ -272,7 +301,7 @@ outer:
 			// -1 是为了确保base值小于0
 			// 当一个key是独立存在的，非前缀，其最后一个字符必是叶子节点，此时left=key的索引
 			//通过状态转移拿到的base值可以还原为left，那么就可以索引到key，后面的exactMatch基于此搜索
-			d.base[begin+chi.code] = -chi.left - 1
+			d.setBase(begin+chi.code, -chi.left-1)
 			// 到叶子节点用掉一个key（不包括公共前缀）
 			d.progress++
 		} else {
@@ -282,12 +311,82 @@ outer:
 				return begin, err
 			}
 			// 状态转移
-			d.base[begin+chi.code] = nexState
+			d.setBase(begin+chi.code, nexState)
 		}
 	}
 	return begin, nil
 }
 
+// appendTail 把chi对应key中从chi.depth开始的剩余字符写入tail缓冲区，
+// 返回应当写入base的tail指针（负数，且与叶子编码-left-1的取值范围不重叠）
+func (d *DoubleArrayTrie) appendTail(chi *node) int {
+	k := d.keys[chi.left]
+	suffix := k[chi.depth:]
+	offset := len(d.tail)
+	d.tail = append(d.tail, suffix...)
+	entryIdx := len(d.tailEntries)
+	d.tailEntries = append(d.tailEntries, tailEntry{offset: offset, length: len(suffix), left: chi.left})
+	return d.tailPointer(entryIdx)
+}
+
+// keyEquals比较d.keys[index]和runes是否相同
+// 双数组trie允许不同节点复用同一个begin值（只要各自占用的cell不冲突），
+// 所以"check[begin+0]==begin"只能说明这个begin下面确实挂着一个NULL转移，
+// 不能保证那个NULL转移就是当前正在搜索的这个begin对应的——必须把解码出来的
+// 叶子key和实际消费掉的输入再比较一遍，才能排除这种巧合导致的误命中
+func (d *DoubleArrayTrie) keyEquals(index int, runes []rune) bool {
+	if index < 0 || index >= len(d.keys) {
+		return false
+	}
+	k := d.keys[index]
+	if len(k) != len(runes) {
+		return false
+	}
+	for i := range k {
+		if k[i] != runes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// tailPointer 把tailEntries的下标编码为base中存放的负数指针
+// 叶子编码的取值范围是[-keySize, -1]（base=-left-1），
+// 这里整体再偏移keySize+1，确保tail指针的取值范围与叶子编码不重叠
+func (d *DoubleArrayTrie) tailPointer(entryIdx int) int {
+	return -(d.keySize + 1) - (entryIdx + 1)
+}
+
+// isTailPointer 判断base中的某个值是否是tail指针（而不是叶子的-left-1编码）
+func (d *DoubleArrayTrie) isTailPointer(v int) bool {
+	return v < -(d.keySize + 1)
+}
+
+// tailEntryIndex 把tail指针还原为tailEntries的下标
+func (d *DoubleArrayTrie) tailEntryIndex(v int) int {
+	return -(v + d.keySize + 2)
+}
+
+// tryTailMatch 尝试用remaining匹配tail指针对应的后缀，
+// ok为true时，length是匹配上的rune数（即entry的长度），entryLeft是该key在keys列表中的索引
+func (d *DoubleArrayTrie) tryTailMatch(tailPtr int, remaining []rune) (entryLeft int, length int, ok bool) {
+	idx := d.tailEntryIndex(tailPtr)
+	if idx < 0 || idx >= len(d.tailEntries) {
+		return 0, 0, false
+	}
+	entry := d.tailEntries[idx]
+	if entry.length > len(remaining) {
+		return 0, 0, false
+	}
+	suffix := d.tail[entry.offset : entry.offset+entry.length]
+	for i := range suffix {
+		if suffix[i] != remaining[i] {
+			return 0, 0, false
+		}
+	}
+	return entry.left, entry.length, true
+}
+
 // 只用keys来build
 func (d *DoubleArrayTrie) Build1(keys []string) error {
 	return d.build_(keys, nil, false)
@@ -304,6 +403,21 @@ func (d *DoubleArrayTrie) BuildWithSort(keys []string) error {
 	return d.build_(keys, nil, true)
 }
 
+// 只用keys来build，开启TAIL后缀压缩模式
+// 构建过程中，一旦搜索范围内只剩一个key，剩余字符会整体写入tail缓冲区而不是逐字符分配base/check，
+// 在长尾缀较多的词库（如自然语言词典）上能显著降低allocSize
+func (d *DoubleArrayTrie) BuildCompressed(keys []string) error {
+	d.compressed = true
+	return d.build_(keys, nil, false)
+}
+
+// 用key和value来build，开启TAIL后缀压缩模式
+// vals必须传入切片
+func (d *DoubleArrayTrie) BuildCompressed2(keys []string, vals interface{}) error {
+	d.compressed = true
+	return d.build_(keys, vals, false)
+}
+
 func (d *DoubleArrayTrie) build_(keys []string, vals interface{}, needSort bool) error {
 	if vals != nil {
 		typeOf := reflect.TypeOf(vals)
@@ -348,7 +462,7 @@ func (d *DoubleArrayTrie) build(keys []string) error {
 		return err
 	}
 	log.Println("first begin = ", begin)
-	d.base[0] = begin // 应该为1
+	d.setBase(0, begin) // 应该为1
 	log.Println("build done...")
 	log.Println("cost:", time.Since(start).Milliseconds(), "ms")
 	log.Println("DAT:", d)
@@ -376,12 +490,21 @@ func (d *DoubleArrayTrie) GetValue(key string) interface{} {
 	}
 	index, ok := d.IndexOf(key)
 	if ok {
-		valueOf := reflect.ValueOf(d.values)
-		return valueOf.Index(index).Interface()
+		return d.valueAt(index)
 	}
 	return nil
 }
 
+// 根据key在keys列表中的索引返回关联的value
+// 没有values时返回nil
+func (d *DoubleArrayTrie) valueAt(index int) interface{} {
+	if d.values == nil {
+		return nil
+	}
+	valueOf := reflect.ValueOf(d.values)
+	return valueOf.Index(index).Interface()
+}
+
 //返回一个key在数组的索引
 //返回
 // int: key在slice中的索引
@@ -410,31 +533,48 @@ func (d *DoubleArrayTrie) ExactMatchSearch(key string) (res int, ok bool) {
 	}
 	chs := []rune(key)
 	kLen := len(chs)
-	begin := d.base[0]
+	begin := d.getBase(0)
 	// root + code(a) -> s1, check[root + code[a]] = root
 	// s1 + code[b] -> s2, check[s1 + code[b]] = s1
 	for i := 0; i < kLen; i++ {
 		// 状态转移函数的输入
 		index := begin + int(chs[i]+1)
-		if d.check[index] != begin {
-			log.Fatalf("error transition, begin = %v, check[index]=%v,code=%c\n", begin, d.check[index], chs[i])
+		if index < 0 || index >= len(d.check) || d.getCheck(index) != begin {
+			// 走不通是正常结果，而不是数据损坏：Delete会清空叶子的check，
+			// 之后对同一个前缀的查询理应查不到，不能再像以前一样log.Fatalf/os.Exit
 			return -1, false
 		}
 		// 转移到下一个状态
-		begin = d.base[index]
+		begin = d.getBase(index)
+		// TAIL压缩模式下，中途可能转移到一个tail指针，后面已经没有base/check了，
+		// 只能把剩余输入和tail里保存的后缀整体比较
+		if d.isTailPointer(begin) {
+			left, length, matched := d.tryTailMatch(begin, chs[i+1:])
+			// 同NULL转移：走到这个tail指针也可能是复用begin的巧合，要核对解码出来的key
+			if matched && length == kLen-i-1 && d.keyEquals(left, chs) {
+				return left, true
+			}
+			return -1, false
+		}
 	}
 	// NULL节点 code 为0
 	index := begin + 0
-	if d.check[index] != begin {
-		log.Fatalf("can't trasfer to NULL node")
+	if index < 0 || index >= len(d.check) || d.getCheck(index) != begin {
+		// 同上：Delete清空NULL转移之后，这里走不通也是正常结果
 		return -1, false
 	}
-	begin = d.base[index]
+	begin = d.getBase(index)
 	// 再转移一次
 	if begin < 0 {
 		// begin = -left -1
 		// left = -begin -1
-		return -begin - 1, true
+		// 两个不同的节点可能共用同一个begin，check[index]==begin可能只是巧合，
+		// 必须确认解码出来的key真的等于key才能当作命中
+		left := -begin - 1
+		if d.keyEquals(left, chs) {
+			return left, true
+		}
+		return -1, false
 	}
 	return -2, false
 }
@@ -445,70 +585,116 @@ func (d *DoubleArrayTrie) String() string {
 	return `[size : ` + size + `,allocSize : ` + alSize + `,keySize: ` + fmt.Sprint(d.keySize) + `,progress: ` + fmt.Sprint(d.progress) + `]`
 }
 
-/*
-原代码中就是搜索key锁包含的所有可能公共前缀
-这里不实现
-*/
-func (d *DoubleArrayTrie) CommonPrefixSearch(key string) []string {
-	subs := make([]string, 1)
-	return subs
+// CommonPrefixResult 表示CommonPrefixSearch命中的一条结果
+type CommonPrefixResult struct {
+	Index  int         // 命中的key在keys列表中的索引
+	Length int         // 命中的前缀长度（rune数）
+	Value  interface{} // 命中key关联的value，没有values时为nil
 }
 
-// 保存build好的DAT到指定路径
-// 使用gob协议
-func (d *DoubleArrayTrie) Store(path string) error {
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, os.ModePerm)
-	if err != nil {
-		log.Fatalln(err)
-		return err
+// CommonPrefixSearch 搜索key包含的所有公共前缀
+// 沿着base/check走一步，就探测一次NULL转移（code为0），
+// 若探测成功且对应base为负数，说明该前缀处存在一个完整的key，记录一条结果
+// 按前缀从短到长的顺序返回所有命中
+func (d *DoubleArrayTrie) CommonPrefixSearch(key string) []CommonPrefixResult {
+	results := make([]CommonPrefixResult, 0)
+	if key == "" {
+		return results
 	}
-	defer file.Close()
-	encoder := gob.NewEncoder(file)
-	dat := new(DATExport)
-
-	dat.AllocSize = d.allocSize
-	dat.Base = d.base
-	dat.Check = d.check
-	dat.Keys = d.keys
-	dat.NextCheckPos = d.nextCheckPos
-	dat.Progress = d.progress
-	dat.Size = d.size
-	dat.Values = d.values
-	dat.KeySize = d.keySize
-
-	err = encoder.Encode(dat)
-	if err != nil {
-		log.Println(err)
-		return err
+	chs := []rune(key)
+	begin := d.getBase(0)
+	for i := 0; i < len(chs); i++ {
+		index := begin + int(chs[i]+1)
+		if index < 0 || index >= len(d.check) || d.getCheck(index) != begin {
+			break
+		}
+		begin = d.getBase(index)
+		// TAIL压缩模式下，中途可能转移到一个tail指针，后面已经没有base/check了，
+		// 剩余输入只要以tail保存的后缀为前缀，就说明当前前缀处存在一个完整的key
+		if d.isTailPointer(begin) {
+			// 同样要核对解码出来的key，排除复用begin导致的巧合
+			if left, length, matched := d.tryTailMatch(begin, chs[i+1:]); matched && d.keyEquals(left, chs[:i+1+length]) {
+				results = append(results, CommonPrefixResult{
+					Index:  left,
+					Length: i + 1 + length,
+					Value:  d.valueAt(left),
+				})
+			}
+			break
+		}
+		// 探测NULL转移，判断当前前缀处是否存在完整的key
+		nullIndex := begin + 0
+		if nullIndex >= 0 && nullIndex < len(d.check) && d.getCheck(nullIndex) == begin {
+			if b := d.getBase(nullIndex); b < 0 && !d.isTailPointer(b) {
+				left := -b - 1
+				// check[begin+0]==begin可能是别的节点复用同一个begin的巧合，
+				// 解码出来的key要和已经消费掉的前缀chs[:i+1]完全一致才能算命中
+				if d.keyEquals(left, chs[:i+1]) {
+					results = append(results, CommonPrefixResult{
+						Index:  left,
+						Length: i + 1,
+						Value:  d.valueAt(left),
+					})
+				}
+			}
+		}
 	}
-	return nil
+	return results
 }
 
-// 从指定路径加载DAT
-func (d *DoubleArrayTrie) Load(path string) error {
-	file, err := os.Open(path)
-	if err != nil {
-		log.Fatalln(err)
-		return err
-	}
-	defer file.Close()
-	decoder := gob.NewDecoder(file)
-	dat := new(DATExport)
-	err = decoder.Decode(dat)
-	if err != nil {
-		log.Fatalln(err)
-		return err
-	}
-
-	d.allocSize = dat.AllocSize
-	d.base = dat.Base
-	d.check = dat.Check
-	d.keys = dat.Keys
-	d.nextCheckPos = dat.NextCheckPos
-	d.progress = dat.Progress
-	d.size = dat.Size
-	d.values = dat.Values
-	d.keySize = dat.KeySize
+// Hit 表示MultiPatternMatch在text中命中的一个片段
+type Hit struct {
+	Start int         // 命中片段在text中的起始rune索引（包括）
+	End   int         // 命中片段在text中的结束rune索引（不包括）
+	Index int         // 命中的key在keys列表中的索引
+	Value interface{} // 命中key关联的value，没有values时为nil
+}
 
-	return nil
+// MultiPatternMatch 在text的每个起始位置上做一次CommonPrefixSearch式的前缀扫描，
+// 返回text中所有命中的key片段。
+// 没有构建AC自动机的fail指针，属于最简单的多模式匹配实现，
+// 由于单次前缀扫描是O(len)的，总体是O(len(text)^2)，在词库场景下足够使用
+func (d *DoubleArrayTrie) MultiPatternMatch(text string) []Hit {
+	hits := make([]Hit, 0)
+	chs := []rune(text)
+	for start := 0; start < len(chs); start++ {
+		begin := d.getBase(0)
+		for i := start; i < len(chs); i++ {
+			index := begin + int(chs[i]+1)
+			if index < 0 || index >= len(d.check) || d.getCheck(index) != begin {
+				break
+			}
+			begin = d.getBase(index)
+			if d.isTailPointer(begin) {
+				if left, length, matched := d.tryTailMatch(begin, chs[i+1:]); matched && d.keyEquals(left, chs[start:i+1+length]) {
+					hits = append(hits, Hit{
+						Start: start,
+						End:   i + 1 + length,
+						Index: left,
+						Value: d.valueAt(left),
+					})
+				}
+				break
+			}
+			nullIndex := begin + 0
+			if nullIndex >= 0 && nullIndex < len(d.check) && d.getCheck(nullIndex) == begin {
+				if b := d.getBase(nullIndex); b < 0 && !d.isTailPointer(b) {
+					left := -b - 1
+					// 同CommonPrefixSearch：排除check[begin+0]==begin只是巧合的情况
+					if d.keyEquals(left, chs[start:i+1]) {
+						hits = append(hits, Hit{
+							Start: start,
+							End:   i + 1,
+							Index: left,
+							Value: d.valueAt(left),
+						})
+					}
+				}
+			}
+		}
+	}
+	return hits
 }
+
+// Store/Load的新二进制格式实现见store.go，
+// 兼容旧版本gob文件的LoadGob，以及LoadMmap也在store.go/mmap_*.go中
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package dat
+
+import "errors"
+
+// LoadMmap 在当前平台没有实现，直接返回一个明确的错误
+func (d *DoubleArrayTrie) LoadMmap(path string) error {
+	return errors.New("dat: LoadMmap is only supported on linux/darwin")
+}
+
+func (d *DoubleArrayTrie) unmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,85 @@
+//go:build linux || darwin
+
+package dat
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// LoadMmap 用只读mmap的方式加载base/check数组，避免把两个大数组整体拷贝进堆内存
+// 多个进程加载同一份词典文件时能共享物理页，适合内存受限、需要跑多个进程的服务场景
+// 通过LoadMmap加载出来的DAT是只读的，不支持Insert/Delete；用完之后应调用Close释放映射
+func (d *DoubleArrayTrie) LoadMmap(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header, err := readHeader(file)
+	if err != nil {
+		return err
+	}
+	headerSize, err := headerBinarySize()
+	if err != nil {
+		return err
+	}
+	baseBytes := int(header.AllocSize) * 4
+	checkBytes := int(header.AllocSize) * 4
+	mmapLen := headerSize + baseBytes + checkBytes
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if int64(mmapLen) > fi.Size() {
+		return errors.New("dat: file too small to hold base/check")
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, mmapLen, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+
+	baseOff := headerSize
+	checkOff := headerSize + baseBytes
+	d.base = unsafe.Slice((*int32)(unsafe.Pointer(&data[baseOff])), header.AllocSize)
+	d.check = unsafe.Slice((*int32)(unsafe.Pointer(&data[checkOff])), header.AllocSize)
+	d.mmapData = data
+	d.keySize = int(header.KeySize)
+	d.size = int(header.Size)
+	d.allocSize = int(header.AllocSize)
+	d.compressed = header.Compressed != 0
+
+	// tail/keys/values体量比base/check小得多，仍然按普通方式读入堆内存，不纳入mmap区域
+	if _, err := file.Seek(int64(mmapLen), io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(file)
+	tail, tailEntries, err := readTail(r, header.TailRuneCount, header.TailEntryCount)
+	if err != nil {
+		return err
+	}
+	keys, err := readKeys(r)
+	if err != nil {
+		return err
+	}
+	values, err := readValues(r, header.Flags)
+	if err != nil {
+		return err
+	}
+	d.tail = tail
+	d.tailEntries = tailEntries
+	d.keys = keys
+	d.values = values
+	return nil
+}
+
+func (d *DoubleArrayTrie) unmap(data []byte) error {
+	return syscall.Munmap(data)
+}
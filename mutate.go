@@ -0,0 +1,356 @@
+package dat
+
+import (
+	"errors"
+	"sort"
+)
+
+// Insert/Delete支持在不重新构建整棵trie的前提下增删key，
+// 代价是相比一次性Build，会在base/check里留下比较分散的空洞（Delete）
+// 或者偶尔触发一次局部搬迁（Insert遇到cell冲突时）
+//
+// 限制：
+//  1. 不支持TAIL压缩模式（BuildCompressed构建）的trie，因为尾部后缀不是逐字符的base/check状态，
+//     没有办法在中途插入新的分支
+//  2. 不支持LoadMmap加载出的只读trie，因为底层内存是只读映射的
+//  3. 还没有Build过（base/check为空）的trie不能Insert/Delete
+//  4. Insert不支持Build2/BuildCompressed2构建、即带有reflect版values的DoubleArrayTrie（Delete不受此限制）——
+//     Insert只知道往d.keys追加新key，没有办法同步往d.values（一个裸interface{}切片）追加对应的value，
+//     继续调用会让GetValue的下标和d.values的长度错位。带value的增量插入请用Trie[V]，
+//     它的values是类型安全的[]V，Insert里本就会同步追加
+var (
+	errCompressedNotSupported = errors.New("dat: Insert/Delete does not support TAIL-compressed trie")
+	errMmapNotSupported       = errors.New("dat: Insert/Delete does not support mmap-backed read-only trie")
+	errValuesNotSupported     = errors.New("dat: Insert does not support a DoubleArrayTrie built with reflect-based values, use Trie[V] instead")
+	errNotBuilt               = errors.New("dat: trie has not been built yet")
+	errKeyExists              = errors.New("dat: key already exists")
+	errEmptyKey               = errors.New("dat: empty key")
+)
+
+// checkAt把越界当成"未分配、可以认为是空闲的"，
+// 因为d.check还没有resize到那个下标时，语义上就等价于check=0
+func (d *DoubleArrayTrie) checkAt(i int) int {
+	if i < 0 || i >= len(d.check) {
+		return 0
+	}
+	return d.getCheck(i)
+}
+
+// ensureChildCodes 第一次做增量写入之前，从已有的keys里重放一遍，建立nodeKey -> 孩子code集合的索引
+// 不能只靠扫描check[]==begin来找一个节点当前的孩子：双数组trie允许不同节点复用同一个begin值
+// （只要它们各自占用的cell不冲突），所以必须按"父cell下标"而不是"begin的数值"来标识一个节点
+func (d *DoubleArrayTrie) ensureChildCodes() {
+	if d.childCodes != nil {
+		return
+	}
+	d.childCodes = make(map[int][]int)
+	for _, k := range d.keys {
+		nodeKey := 0
+		begin := d.getBase(0)
+		for i := 0; i < len(k); i++ {
+			code := int(k[i] + 1)
+			d.addChildCode(nodeKey, code)
+			index := begin + code
+			nodeKey = index
+			begin = d.getBase(index)
+			if d.isTailPointer(begin) {
+				// TAIL压缩trie不支持动态写入，Insert/Delete已经提前拦截，这里不会真正发生
+				break
+			}
+		}
+		d.addChildCode(nodeKey, 0)
+	}
+}
+
+func (d *DoubleArrayTrie) addChildCode(nodeKey int, code int) {
+	codes := d.childCodes[nodeKey]
+	for _, c := range codes {
+		if c == code {
+			return
+		}
+	}
+	d.childCodes[nodeKey] = append(codes, code)
+}
+
+func (d *DoubleArrayTrie) removeChildCode(nodeKey int, code int) {
+	codes := d.childCodes[nodeKey]
+	for i, c := range codes {
+		if c == code {
+			d.childCodes[nodeKey] = append(codes[:i], codes[i+1:]...)
+			return
+		}
+	}
+}
+
+// Insert 往trie里新增一个key，返回它在keys列表中的索引
+// 做法：先沿着已有的base/check尽量往下走，找到和已有内容重合的最长前缀；
+// 从分叉点开始，如果目标cell空闲就直接占用，否则把分叉点这个节点的所有孩子
+// （包括新增的这一个）一起搬迁到一个新的begin，并修正父节点指向它的base指针
+func (d *DoubleArrayTrie) Insert(key string) (int, error) {
+	if d.allocSize == 0 {
+		return 0, errNotBuilt
+	}
+	if d.compressed {
+		return 0, errCompressedNotSupported
+	}
+	if d.mmapData != nil {
+		return 0, errMmapNotSupported
+	}
+	if d.values != nil {
+		return 0, errValuesNotSupported
+	}
+	if key == "" {
+		return 0, errEmptyKey
+	}
+	chs := []rune(key)
+	d.ensureChildCodes()
+
+	// 沿着已有的base/check往下走，直到第一个不存在的转移
+	// nodeKey标识begin这个节点自身（根节点是0，否则是指向它的父cell下标），
+	// parentBegin/parentCode记录最后一跳的父cell，方便分叉点需要搬迁时回写父节点的base
+	begin := d.getBase(0)
+	nodeKey := 0
+	parentBegin := -1
+	parentCode := 0
+	depth := 0
+	for depth < len(chs) {
+		code := int(chs[depth] + 1)
+		index := begin + code
+		if d.checkAt(index) != begin {
+			break
+		}
+		parentBegin = begin
+		parentCode = code
+		nodeKey = index
+		begin = d.getBase(index)
+		if d.isTailPointer(begin) {
+			return 0, errCompressedNotSupported
+		}
+		depth++
+	}
+
+	// depth是匹配到的前缀长度，begin/nodeKey是这个前缀对应的节点，
+	// 还需要在这个节点上为剩下的部分增加一条转移：
+	// depth<len(chs)时是chs[depth]，depth==len(chs)时是NULL(code=0)代表单词结束
+	code := 0
+	if depth < len(chs) {
+		code = int(chs[depth] + 1)
+	}
+	targetIndex := begin + code
+	if d.checkAt(targetIndex) == begin {
+		// 转移已经存在
+		if depth == len(chs) {
+			if b := d.getBase(targetIndex); b < 0 {
+				return 0, errKeyExists
+			}
+		}
+		// depth<len(chs)且转移已存在，理论上应该在上面的循环里继续往前走，不会走到这里
+		return 0, errors.New("dat: unexpected existing transition while inserting")
+	}
+
+	leafIndex := len(d.keys)
+	leafBase := -leafIndex - 1
+
+	// 这条新transition指向的状态的value：
+	// depth==len(chs)时，分叉transition本身就是NULL步，直接写叶子编码；
+	// depth<len(chs)时，分叉transition之后还剩chs[depth+1:]这些字符要走完，
+	// 再加一个NULL终止步，每一级都只有唯一的孩子，用allocChain链式分配全新的状态
+	// firstChainCode是这条新链条最外层状态自己的那个唯一孩子的code（allocChain内部不知道
+	// 最外层状态的nodeKey是谁，没法帮它记这一条，只能由这里记）；childBase是叶子编码本身时为-1（不需要记）
+	childBase := leafBase
+	firstChainCode := -1
+	if depth < len(chs) {
+		childBase = d.allocChain(chs[depth+1:], leafBase)
+		firstChainCode = 0
+		if depth+1 < len(chs) {
+			firstChainCode = int(chs[depth+1] + 1)
+		}
+	}
+
+	if d.checkAt(targetIndex) == 0 {
+		// cell空闲，直接占用，不需要搬迁
+		if targetIndex >= d.allocSize {
+			d.resize(int(float64(targetIndex+1) * 1.2))
+		}
+		d.setCheck(targetIndex, begin)
+		d.setBase(targetIndex, childBase)
+		if targetIndex+1 > d.size {
+			d.size = targetIndex + 1
+		}
+		d.addChildCode(nodeKey, code)
+		if firstChainCode >= 0 {
+			d.addChildCode(targetIndex, firstChainCode)
+		}
+	} else {
+		// cell被别的节点占用，把当前节点的所有孩子（已有的+新增的）一起搬迁到一个新的begin
+		codes := append([]int{}, d.childCodes[nodeKey]...)
+		codes = append(codes, code)
+		sort.Ints(codes)
+		newBegin := d.relocate(begin, codes, code, childBase)
+		if parentBegin == -1 {
+			d.setBase(0, newBegin)
+		} else {
+			d.setBase(parentBegin+parentCode, newBegin)
+		}
+		d.childCodes[nodeKey] = codes
+		if firstChainCode >= 0 {
+			d.addChildCode(newBegin+code, firstChainCode)
+		}
+	}
+
+	d.keys = append(d.keys, chs)
+	d.keySize++
+	return leafIndex, nil
+}
+
+// allocChain 为key剩余的字符(rest)再加上最后的NULL终止步，从叶子往回链式分配一串全新的、
+// 每一级都只有唯一孩子的状态，返回链条起点的begin（也就是分叉transition的base应该指向的值）
+// 因为每一级都是全新分配的状态，用findBegin就一定能找到空闲位置，不会和已有的任何节点冲突
+//
+// 链条里每一级状态的nodeKey，都是"指向它的那个cell"，也就是上一级（更靠外）分配出来的cell下标，
+// 所以只能等上一级分配完才能把这一级的childCodes记下来；最外层（链条起点）的nodeKey由调用者
+// （Insert里的targetIndex/relocate的newIdx）决定，不归这个函数管
+func (d *DoubleArrayTrie) allocChain(rest []rune, leafBase int) int {
+	prevBegin := leafBase
+	prevCode := -1 // leafBase不是一个状态的begin，不需要给它记录childCodes
+	for i := len(rest); i >= 0; i-- {
+		code := 0
+		if i < len(rest) {
+			code = int(rest[i] + 1)
+		}
+		begin := d.findBegin([]int{code})
+		idx := begin + code
+		if idx >= d.allocSize {
+			d.resize(int(float64(idx+1) * 1.2))
+		}
+		d.setCheck(idx, begin)
+		d.setBase(idx, prevBegin)
+		if idx+1 > d.size {
+			d.size = idx + 1
+		}
+		if prevCode >= 0 {
+			// idx正是上一轮创建的状态的nodeKey：base[idx]==上一轮的begin
+			d.addChildCode(idx, prevCode)
+		}
+		prevBegin = begin
+		prevCode = code
+	}
+	return prevBegin
+}
+
+// relocate 为codes描述的孩子集合（已有孩子+新增孩子）找一个所有cell都空闲的新begin，
+// 把已有孩子的cell从旧begin搬到新begin，新增孩子(newCode)的cell直接写入newChildBase，
+// 旧cell搬空后清零，让这块位置重新可用
+func (d *DoubleArrayTrie) relocate(oldBegin int, codes []int, newCode int, newChildBase int) int {
+	newBegin := d.findBegin(codes)
+	for _, c := range codes {
+		newIdx := newBegin + c
+		if newIdx >= d.allocSize {
+			d.resize(int(float64(newIdx+1) * 1.2))
+		}
+		d.setCheck(newIdx, newBegin)
+		if c == newCode {
+			d.setBase(newIdx, newChildBase)
+			continue
+		}
+		oldIdx := oldBegin + c
+		d.setBase(newIdx, d.getBase(oldIdx))
+		d.setBase(oldIdx, 0)
+		d.setCheck(oldIdx, 0)
+		// oldIdx这个cell本身也可能是某个更深的状态的nodeKey（它的base指向那个状态的begin），
+		// 搬到newIdx后，那个更深状态的"身份"也要跟着改名，否则childCodes会变成野指针
+		if moved, ok := d.childCodes[oldIdx]; ok {
+			d.childCodes[newIdx] = moved
+			delete(d.childCodes, oldIdx)
+		}
+	}
+	if s := newBegin + codes[len(codes)-1] + 1; d.size < s {
+		d.size = s
+	}
+	return newBegin
+}
+
+// findBegin 用和build阶段insert()一样的启发式方法，为codes找一个所有cell都空闲的begin
+// 同insert()一样，要把探测过程中第一个空闲位置和"95%都被占用"的信息写回d.nextCheckPos，
+// 否则每次Insert都要从上一次的起点重新扫描，随着已插入的key增多，扫描区间线性变长，
+// 总体退化成二次复杂度
+func (d *DoubleArrayTrie) findBegin(codes []int) int {
+	pos := codes[0]
+	if d.nextCheckPos > codes[0] {
+		pos = d.nextCheckPos - 1
+	}
+	firstNonZero := true
+	nonZeroNum := 0
+outer:
+	for {
+		pos++
+		begin := pos - codes[0]
+		if begin < 1 {
+			continue
+		}
+		if pos < d.allocSize && d.getCheck(pos) != 0 {
+			nonZeroNum++
+			continue
+		} else if firstNonZero {
+			d.nextCheckPos = pos
+			firstNonZero = false
+		}
+		if s := begin + codes[len(codes)-1] + 1; s > d.allocSize {
+			d.resize(int(float64(s) * 1.2))
+		}
+		for i := 1; i < len(codes); i++ {
+			if d.getCheck(begin+codes[i]) != 0 {
+				continue outer
+			}
+		}
+		if s := pos - d.nextCheckPos + 1; s > 0 && float64(nonZeroNum*1.0/s) >= 0.95 {
+			d.nextCheckPos = pos
+		}
+		return begin
+	}
+}
+
+// Delete 从trie中删除一个key，返回是否真的删掉了（key不存在或者只是公共前缀时返回false）
+// 只是把叶子对应的cell清零，让这个位置重新变为未占用；不会收缩base/check的size，
+// 也不会尝试把父节点唯一剩下的孩子重新压缩到更紧凑的位置——这些留给下一次全量rebuild
+func (d *DoubleArrayTrie) Delete(key string) bool {
+	if d.allocSize == 0 {
+		return false
+	}
+	if d.compressed || d.mmapData != nil {
+		return false
+	}
+	if key == "" {
+		return false
+	}
+	chs := []rune(key)
+	d.ensureChildCodes()
+
+	begin := d.getBase(0)
+	nodeKey := 0
+	for i := 0; i < len(chs); i++ {
+		code := int(chs[i] + 1)
+		index := begin + code
+		if d.checkAt(index) != begin {
+			return false
+		}
+		nodeKey = index
+		begin = d.getBase(index)
+		if d.isTailPointer(begin) {
+			return false
+		}
+	}
+	nullIndex := begin + 0
+	if d.checkAt(nullIndex) != begin {
+		return false
+	}
+	leafBase := d.getBase(nullIndex)
+	if leafBase >= 0 {
+		// 是别的key的公共前缀，不是一个完整的key，不能删
+		return false
+	}
+	d.setBase(nullIndex, 0)
+	d.setCheck(nullIndex, 0)
+	d.removeChildCode(nodeKey, 0)
+	return true
+}
@@ -0,0 +1,172 @@
+package dat
+
+import (
+	"log"
+	"strconv"
+	"testing"
+)
+
+// 测试Insert：新增的key不重新build就能被查到，已有key不受影响
+func TestInsert(t *testing.T) {
+	dat := NewDoubleArrayTrie()
+	if err := dat.Build1([]string{"a", "ab", "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dat.Insert("abd"); err != nil {
+		t.Fatalf("Insert(abd) failed: %v", err)
+	}
+	if _, err := dat.Insert("b"); err != nil {
+		t.Fatalf("Insert(b) failed: %v", err)
+	}
+	for _, key := range []string{"a", "ab", "abc", "abd", "b"} {
+		if _, ok := dat.IndexOf(key); !ok {
+			t.Errorf("IndexOf(%s) = not found after Insert", key)
+		}
+	}
+	if _, err := dat.Insert("a"); err == nil {
+		t.Error("expect error when inserting an existing key")
+	}
+}
+
+// 测试Insert会持续正确处理cell冲突触发的搬迁场景：
+// 用一批互相密集共享前缀的key反复insert，保证每次插入后已有的key都还能查到
+func TestInsertWithRelocation(t *testing.T) {
+	base := []string{"ab", "ac", "ad", "ae", "af"}
+	dat := NewDoubleArrayTrie()
+	if err := dat.Build1(base); err != nil {
+		t.Fatal(err)
+	}
+	extra := []string{"ag", "ah", "ai", "aj", "ba", "bb", "bc"}
+	inserted := append([]string{}, base...)
+	for _, key := range extra {
+		if _, err := dat.Insert(key); err != nil {
+			t.Fatalf("Insert(%s) failed: %v", key, err)
+		}
+		inserted = append(inserted, key)
+		for _, k := range inserted {
+			if _, ok := dat.IndexOf(k); !ok {
+				t.Fatalf("after inserting %s, IndexOf(%s) = not found", key, k)
+			}
+		}
+	}
+}
+
+// 测试Delete：删除后key查不到了，其它key不受影响；删除公共前缀或不存在的key应该返回false
+func TestDelete(t *testing.T) {
+	dat := NewDoubleArrayTrie()
+	keys := []string{"a", "ab", "abc", "b"}
+	if err := dat.Build1(keys); err != nil {
+		t.Fatal(err)
+	}
+	if ok := dat.Delete("ab"); !ok {
+		t.Fatal("expect Delete(ab) to succeed")
+	}
+	if _, ok := dat.IndexOf("ab"); ok {
+		t.Error("expect IndexOf(ab) = not found after Delete")
+	}
+	for _, key := range []string{"a", "abc", "b"} {
+		if _, ok := dat.IndexOf(key); !ok {
+			t.Errorf("IndexOf(%s) = not found after unrelated Delete", key)
+		}
+	}
+	if ok := dat.Delete("ab"); ok {
+		t.Error("expect second Delete(ab) to fail, key already removed")
+	}
+	if ok := dat.Delete("xyz"); ok {
+		t.Error("expect Delete of a non-existent key to fail")
+	}
+}
+
+// 测试Insert/Delete在TAIL压缩模式的trie上会返回明确的不支持错误，而不是panic或者数据错乱
+func TestInsertDeleteRejectsCompressed(t *testing.T) {
+	dat := NewDoubleArrayTrie()
+	samples := makeSample(1000, 3, 8)
+	if err := dat.BuildCompressed(samples); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dat.Insert("zzznotexist"); err == nil {
+		t.Error("expect Insert to reject a TAIL-compressed trie")
+	}
+	if ok := dat.Delete(samples[0]); ok {
+		t.Error("expect Delete to reject a TAIL-compressed trie")
+	}
+}
+
+// 测试Insert在一个还没Build过的DoubleArrayTrie上返回明确的错误，而不是索引越界panic
+func TestInsertDeleteRejectsNotBuilt(t *testing.T) {
+	dat := NewDoubleArrayTrie()
+	if _, err := dat.Insert("a"); err != errNotBuilt {
+		t.Errorf("Insert on unbuilt trie = %v, want errNotBuilt", err)
+	}
+	if ok := dat.Delete("a"); ok {
+		t.Error("expect Delete on unbuilt trie to return false")
+	}
+}
+
+// 测试Insert在Build2构建、带reflect版values的DoubleArrayTrie上返回明确的错误，
+// 而不是让d.values和d.keys长度错位，导致后续GetValue panic
+func TestInsertRejectsReflectValues(t *testing.T) {
+	dat := NewDoubleArrayTrie()
+	if err := dat.Build2([]string{"a", "ab"}, []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dat.Insert("abd"); err != errValuesNotSupported {
+		t.Errorf("Insert on DoubleArrayTrie with values = %v, want errValuesNotSupported", err)
+	}
+}
+
+// 测试泛型Trie上的Insert/Delete，value能和key同步增删
+func TestTrieInsertDelete(t *testing.T) {
+	trie := NewTrie[int]()
+	if err := trie.Build([]string{"a", "ab"}, []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := trie.Insert("abc", 3); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := trie.Get("abc"); !ok || v != 3 {
+		t.Errorf("Get(abc) = %d, %v, want 3, true", v, ok)
+	}
+	if ok := trie.Delete("ab"); !ok {
+		t.Fatal("expect Delete(ab) to succeed")
+	}
+	if _, ok := trie.Get("ab"); ok {
+		t.Error("expect Get(ab) = not found after Delete")
+	}
+	if v, ok := trie.Get("a"); !ok || v != 1 {
+		t.Errorf("Get(a) = %d, %v, want 1, true", v, ok)
+	}
+}
+
+// 对比：插入一批新key到已有1M词库的trie里，Insert的增量耗时 vs 整体重新Build的耗时
+// 用来衡量增量插入什么规模下还划算——当插入批量逐渐接近词库总量时，
+// 频繁触发的搬迁会让Insert的总耗时追上甚至超过一次Build
+func BenchmarkInsertVsRebuild(b *testing.B) {
+	log.SetOutput(&NilWriter{})
+	base := makeSample(1000000, 3, 8)
+	extra := makeSample(1000, 9, 12)
+
+	b.Run("Insert_1000_into_1M", func(b *testing.B) {
+		dat := NewDoubleArrayTrie()
+		if err := dat.Build1(base); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			// 每一轮给key加上轮次后缀，保证每一轮插入的都是trie里还没有的新key
+			suffix := strconv.Itoa(i)
+			for _, key := range extra {
+				_, _ = dat.Insert(key + suffix)
+			}
+		}
+	})
+
+	b.Run("FullRebuild_1M_plus_1000", func(b *testing.B) {
+		all := append(append([]string{}, base...), extra...)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			dat := NewDoubleArrayTrie()
+			_ = dat.BuildWithSort(all)
+		}
+	})
+}
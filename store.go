@@ -0,0 +1,398 @@
+package dat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// 新版二进制存储格式：
+//	header | base([]int32裸数组) | check([]int32裸数组) | tail(runes) | tailEntries | keys | values
+// header是定长的，各字段按小端序编码：
+//	magic[4]         文件标识，固定为"DAT1"
+//	version          格式版本号，用来以后升级格式时做兼容判断
+//	keySize          key的数量
+//	size             base/check真正用到的大小
+//	allocSize        base/check分配的大小，也是紧随其后的base/check数组各自的元素个数
+//	flags            标记values段的编码方式，见flagValues*
+//	compressed       是否是TAIL压缩模式（BuildCompressed）构建的trie
+//	tailRuneCount    tail缓冲区的rune数，也是紧随base/check之后tail段的元素个数
+//	tailEntryCount   tailEntries的条数
+// 相比旧版的gob格式，base/check以定长int32裸写入/裸读出，不需要经过reflect，
+// 加载时也不必把两个大数组整体拷贝进一份新的interface{}再解析，内存占用更小
+const (
+	datMagic   = "DAT1"
+	datVersion = uint32(2)
+
+	flagValuesNone = uint32(0) // 没有values
+	flagValuesGob  = uint32(1) // values用gob编码
+	flagValuesJSON = uint32(2) // values用JSON编码
+)
+
+// datHeader是文件头，字段全部是定长类型，binary.Size能准确算出编码后的字节数
+type datHeader struct {
+	Magic          [4]byte
+	Version        uint32
+	KeySize        uint64
+	Size           uint64
+	AllocSize      uint64
+	Flags          uint32
+	Compressed     uint32
+	TailRuneCount  uint64
+	TailEntryCount uint64
+}
+
+// 由于不想对外暴露DoubleArrayTrie的字段，但是gob协议中又需要编码
+// 所以被迫这里使用一个中间结构来达到目的
+// 仅用于兼容旧版本Store写出的gob文件，新格式不再使用它
+type DATExport struct {
+	Check        []int
+	Base         []int
+	Size         int
+	AllocSize    int
+	Keys         []key
+	KeySize      int
+	Values       interface{}
+	Progress     int
+	NextCheckPos int
+}
+
+// Store 用新的二进制格式保存DAT到指定路径
+// values按gob编码存储，与旧版本Store的行为一致：values的具体类型需要能被gob处理
+// （切片、map等复合类型建议提前调用gob.Register注册元素类型）
+// 对values有跨语言互操作需求，可以用StoreJSON代替
+func (d *DoubleArrayTrie) Store(path string) error {
+	return d.storeWithFlags(path, flagValuesGob)
+}
+
+// StoreJSON 与Store类似，只是values段改用JSON编码
+// 注意JSON解码不保留values原有的具体类型（例如[]int解码回来会变成[]interface{}），
+// 只在调用方不关心values的具体类型、或者values本身就是JSON友好类型时使用
+func (d *DoubleArrayTrie) StoreJSON(path string) error {
+	return d.storeWithFlags(path, flagValuesJSON)
+}
+
+func (d *DoubleArrayTrie) storeWithFlags(path string, flags uint32) error {
+	if d.values == nil {
+		flags = flagValuesNone
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		log.Fatalln(err)
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	compressed := uint32(0)
+	if d.compressed {
+		compressed = 1
+	}
+	header := datHeader{
+		Version:        datVersion,
+		KeySize:        uint64(d.keySize),
+		Size:           uint64(d.size),
+		AllocSize:      uint64(d.allocSize),
+		Flags:          flags,
+		Compressed:     compressed,
+		TailRuneCount:  uint64(len(d.tail)),
+		TailEntryCount: uint64(len(d.tailEntries)),
+	}
+	copy(header.Magic[:], datMagic)
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, d.base); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, d.check); err != nil {
+		return err
+	}
+	if err := writeTail(w, d.tail, d.tailEntries); err != nil {
+		return err
+	}
+	if err := writeKeys(w, d.keys); err != nil {
+		return err
+	}
+	if err := writeValues(w, d.values, flags); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// writeTail写出TAIL压缩模式下的tail缓冲区和它的元信息段
+// rune本身就是int32的别名，tail可以直接当[]int32裸写；tailEntries按固定宽度的int64三元组写出，
+// 不依赖平台相关的int宽度
+func writeTail(w io.Writer, tail []rune, entries []tailEntry) error {
+	if len(tail) > 0 {
+		if err := binary.Write(w, binary.LittleEndian, tail); err != nil {
+			return err
+		}
+	}
+	for _, e := range entries {
+		if err := binary.Write(w, binary.LittleEndian, [3]int64{int64(e.offset), int64(e.length), int64(e.left)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readTail与writeTail对应，runeCount/entryCount来自header，用来确定各自要读多少
+func readTail(r io.Reader, runeCount uint64, entryCount uint64) ([]rune, []tailEntry, error) {
+	var tail []rune
+	if runeCount > 0 {
+		tail = make([]rune, runeCount)
+		if err := binary.Read(r, binary.LittleEndian, tail); err != nil {
+			return nil, nil, err
+		}
+	}
+	entries := make([]tailEntry, entryCount)
+	for i := range entries {
+		var raw [3]int64
+		if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
+			return nil, nil, err
+		}
+		entries[i] = tailEntry{offset: int(raw[0]), length: int(raw[1]), left: int(raw[2])}
+	}
+	return tail, entries, nil
+}
+
+func writeKeys(w io.Writer, keys []key) error {
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(keys))); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		s := string(k)
+		if err := binary.Write(w, binary.LittleEndian, uint32(len(s))); err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readKeys(r io.Reader) ([]key, error) {
+	var count uint64
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	keys := make([]key, count)
+	for i := range keys {
+		var strLen uint32
+		if err := binary.Read(r, binary.LittleEndian, &strLen); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, strLen)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		keys[i] = []rune(string(buf))
+	}
+	return keys, nil
+}
+
+// valuesEnvelope把values包在一个具体的struct字段里再交给gob编解码
+// 直接对一个裸interface{}调用Decode要求先gob.Register具体类型，
+// 而放在已知struct字段里，gob对[]int、[]string这类内置类型不需要额外注册
+type valuesEnvelope struct {
+	Values interface{}
+}
+
+func writeValues(w io.Writer, values interface{}, flags uint32) error {
+	switch flags {
+	case flagValuesNone:
+		return nil
+	case flagValuesGob:
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(&valuesEnvelope{Values: values}); err != nil {
+			return err
+		}
+		return writeBlob(w, buf.Bytes())
+	case flagValuesJSON:
+		data, err := json.Marshal(values)
+		if err != nil {
+			return err
+		}
+		return writeBlob(w, data)
+	default:
+		return fmt.Errorf("dat: unsupported values flag %d", flags)
+	}
+}
+
+func readValues(r io.Reader, flags uint32) (interface{}, error) {
+	switch flags {
+	case flagValuesNone:
+		return nil, nil
+	case flagValuesGob:
+		blob, err := readBlob(r)
+		if err != nil {
+			return nil, err
+		}
+		var envelope valuesEnvelope
+		if err := gob.NewDecoder(bytes.NewReader(blob)).Decode(&envelope); err != nil {
+			return nil, err
+		}
+		return envelope.Values, nil
+	case flagValuesJSON:
+		blob, err := readBlob(r)
+		if err != nil {
+			return nil, err
+		}
+		var values interface{}
+		if err := json.Unmarshal(blob, &values); err != nil {
+			return nil, err
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("dat: unsupported values flag %d", flags)
+	}
+}
+
+func writeBlob(w io.Writer, data []byte) error {
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(data))); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func readBlob(r io.Reader) ([]byte, error) {
+	var n uint64
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	blob := make([]byte, n)
+	if _, err := io.ReadFull(r, blob); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+func readHeader(r io.Reader) (datHeader, error) {
+	var header datHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return header, err
+	}
+	if string(header.Magic[:]) != datMagic {
+		return header, errors.New("dat: invalid file, magic mismatch")
+	}
+	if header.Version != datVersion {
+		return header, fmt.Errorf("dat: unsupported format version %d", header.Version)
+	}
+	return header, nil
+}
+
+// headerBinarySize返回header编码后的字节数，用来定位base/check在文件中的偏移量
+func headerBinarySize() (int, error) {
+	n := binary.Size(datHeader{})
+	if n < 0 {
+		return 0, errors.New("dat: invalid header type")
+	}
+	return n, nil
+}
+
+// Load 从指定路径加载DAT，读取的是Store/StoreJSON写出的新二进制格式
+// base/check会被整体读入堆内存；如果只是只读查询、且希望多进程共享同一份词典的物理页，用LoadMmap
+func (d *DoubleArrayTrie) Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalln(err)
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	header, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	base := make([]int32, header.AllocSize)
+	if err := binary.Read(r, binary.LittleEndian, base); err != nil {
+		return err
+	}
+	check := make([]int32, header.AllocSize)
+	if err := binary.Read(r, binary.LittleEndian, check); err != nil {
+		return err
+	}
+	tail, tailEntries, err := readTail(r, header.TailRuneCount, header.TailEntryCount)
+	if err != nil {
+		return err
+	}
+	keys, err := readKeys(r)
+	if err != nil {
+		return err
+	}
+	values, err := readValues(r, header.Flags)
+	if err != nil {
+		return err
+	}
+
+	d.base = base
+	d.check = check
+	d.tail = tail
+	d.tailEntries = tailEntries
+	d.compressed = header.Compressed != 0
+	d.keys = keys
+	d.keySize = int(header.KeySize)
+	d.size = int(header.Size)
+	d.allocSize = int(header.AllocSize)
+	d.values = values
+	return nil
+}
+
+// LoadGob 从旧版本（gob格式）的Store文件中加载DAT，仅用于兼容磁盘上已有的历史文件
+// 新写出的文件请使用Store/StoreJSON+Load/LoadMmap
+func (d *DoubleArrayTrie) LoadGob(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalln(err)
+		return err
+	}
+	defer file.Close()
+	decoder := gob.NewDecoder(file)
+	dat := new(DATExport)
+	err = decoder.Decode(dat)
+	if err != nil {
+		log.Fatalln(err)
+		return err
+	}
+
+	d.allocSize = dat.AllocSize
+	d.base = toInt32Slice(dat.Base)
+	d.check = toInt32Slice(dat.Check)
+	d.keys = dat.Keys
+	d.nextCheckPos = dat.NextCheckPos
+	d.progress = dat.Progress
+	d.size = dat.Size
+	d.values = dat.Values
+	d.keySize = dat.KeySize
+
+	return nil
+}
+
+func toInt32Slice(src []int) []int32 {
+	dst := make([]int32, len(src))
+	for i, v := range src {
+		dst[i] = int32(v)
+	}
+	return dst
+}
+
+// Close 释放LoadMmap映射的内存；非mmap加载的DAT调用是no-op
+func (d *DoubleArrayTrie) Close() error {
+	if d.mmapData == nil {
+		return nil
+	}
+	err := d.unmap(d.mmapData)
+	d.mmapData = nil
+	return err
+}
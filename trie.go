@@ -0,0 +1,99 @@
+package dat
+
+import "errors"
+
+// Trie是DoubleArrayTrie的泛型包装
+// GetValue的热路径要经过reflect.ValueOf(...).Index(...).Interface()，
+// 既有额外的类型断言开销又会产生逃逸分配；Trie把values换成类型安全的[]V，
+// Get/CommonPrefixValues/MultiPatternMatch都直接下标访问，不再经过reflect
+// 底层的base/check状态机逻辑完全复用DoubleArrayTrie，只是values的存取方式不同
+type Trie[V any] struct {
+	dat    *DoubleArrayTrie
+	values []V
+}
+
+// NewTrie 创建一个空的泛型Trie
+func NewTrie[V any]() *Trie[V] {
+	return &Trie[V]{dat: NewDoubleArrayTrie()}
+}
+
+// Build 用keys和vals构建trie，vals[i]是keys[i]对应的value，两者长度必须一致
+func (t *Trie[V]) Build(keys []string, vals []V) error {
+	if len(keys) != len(vals) {
+		return errors.New("dat: keys and vals must have the same length")
+	}
+	t.values = vals
+	return t.dat.Build1(keys)
+}
+
+// Get 返回key对应的value
+// ok为false时表示key不存在，或者key只是其他key的公共前缀
+func (t *Trie[V]) Get(key string) (V, bool) {
+	var zero V
+	index, ok := t.dat.IndexOf(key)
+	if !ok {
+		return zero, false
+	}
+	return t.values[index], true
+}
+
+// CommonPrefixValues 返回key包含的所有公共前缀对应的value，按前缀从短到长排列
+func (t *Trie[V]) CommonPrefixValues(key string) []V {
+	results := t.dat.CommonPrefixSearch(key)
+	values := make([]V, 0, len(results))
+	for _, r := range results {
+		values = append(values, t.values[r.Index])
+	}
+	return values
+}
+
+// TypedHit是Trie.MultiPatternMatch命中的一个片段，Value是类型安全的V
+// 之所以不直接叫Hit，是因为DoubleArrayTrie.MultiPatternMatch已经用了这个名字（Value为interface{}），
+// 两者同时导出，用不同名字加以区分
+type TypedHit[V any] struct {
+	Start int // 命中片段在text中的起始rune索引（包括）
+	End   int // 命中片段在text中的结束rune索引（不包括）
+	Index int // 命中的key在keys列表中的索引
+	Value V   // 命中key关联的value
+}
+
+// MultiPatternMatch 与DoubleArrayTrie.MultiPatternMatch等价，只是Value是类型安全的V
+func (t *Trie[V]) MultiPatternMatch(text string) []TypedHit[V] {
+	rawHits := t.dat.MultiPatternMatch(text)
+	hits := make([]TypedHit[V], 0, len(rawHits))
+	for _, h := range rawHits {
+		hits = append(hits, TypedHit[V]{
+			Start: h.Start,
+			End:   h.End,
+			Index: h.Index,
+			Value: t.values[h.Index],
+		})
+	}
+	return hits
+}
+
+// GetKeySize 获取key的数量
+func (t *Trie[V]) GetKeySize() int {
+	return t.dat.GetKeySize()
+}
+
+// Insert 往trie里新增一个key/value，不需要重新构建整棵trie
+// 具体的增量插入逻辑在DoubleArrayTrie.Insert里，这里只是在它返回新key的索引后同步追加value
+func (t *Trie[V]) Insert(key string, value V) error {
+	index, err := t.dat.Insert(key)
+	if err != nil {
+		return err
+	}
+	if index < len(t.values) {
+		t.values[index] = value
+	} else {
+		t.values = append(t.values, value)
+	}
+	return nil
+}
+
+// Delete 从trie中删除一个key，返回是否真的删掉了
+// 对应的value不会从values里物理移除（避免让其它key的索引跟着变化），只是不再能通过Get访问到
+func (t *Trie[V]) Delete(key string) bool {
+	return t.dat.Delete(key)
+}
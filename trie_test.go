@@ -0,0 +1,77 @@
+package dat
+
+import (
+	"log"
+	"testing"
+)
+
+// 测试泛型Trie的Build、Get、CommonPrefixValues、MultiPatternMatch
+func TestTrieBuildAndGet(t *testing.T) {
+	keys := []string{"a", "ab", "abc", "b"}
+	vals := []int{1, 2, 3, 4}
+	trie := NewTrie[int]()
+	if err := trie.Build(keys, vals); err != nil {
+		t.Fatal(err)
+	}
+	for i, k := range keys {
+		v, ok := trie.Get(k)
+		if !ok || v != vals[i] {
+			t.Errorf("Get(%s) = %d, %v, want %d, true", k, v, ok, vals[i])
+		}
+	}
+	prefixVals := trie.CommonPrefixValues("abcd")
+	if len(prefixVals) != 3 {
+		t.Fatalf("expect 3 prefix values, got %d", len(prefixVals))
+	}
+
+	hits := trie.MultiPatternMatch("xabcy")
+	if len(hits) == 0 {
+		t.Fatal("expect at least 1 hit")
+	}
+	for _, hit := range hits {
+		if vals[hit.Index] != hit.Value {
+			t.Errorf("hit value mismatch: got %d, want %d", hit.Value, vals[hit.Index])
+		}
+	}
+}
+
+// 测试Build时keys和vals长度不一致的错误处理
+func TestTrieBuildLengthMismatch(t *testing.T) {
+	trie := NewTrie[int]()
+	err := trie.Build([]string{"a", "b"}, []int{1})
+	if err == nil {
+		t.Fatal("expect error when keys and vals have different length")
+	}
+}
+
+// 对比GetValue（reflect路径）和Trie.Get（类型化路径）在1M词库下的性能差异
+func BenchmarkGetValueVsTrieGet(b *testing.B) {
+	log.SetOutput(&NilWriter{})
+	samples := makeSample(1000000, 3, 8)
+	vals := make([]int, len(samples))
+	for i := range samples {
+		vals[i] = i
+	}
+
+	dat := NewDoubleArrayTrie()
+	if err := dat.Build2(samples, vals); err != nil {
+		b.Fatal(err)
+	}
+	trie := NewTrie[int]()
+	if err := trie.Build(samples, vals); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("GetValue_reflect", func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_ = dat.GetValue(samples[i%len(samples)])
+		}
+	})
+	b.Run("Trie_Get", func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, _ = trie.Get(samples[i%len(samples)])
+		}
+	})
+}